@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kittenbark/config"
+	"github.com/kittenbark/config/config_web"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "instantiate" {
+		instantiate(os.Args[2:])
+		return
+	}
+
+	addr := flag.String("addr", ":8080", "address to listen on")
+	directory := flag.String("dir", ".", "directory with the configs")
+	syncTimeout := flag.Duration("sync-timeout", time.Minute, "how long configs are served without checking the directory")
+	proxyOf := flag.String("proxy-of", "", "upstream config_web server to serve configs of from memory, e.g. http://config:8080")
+	proxyTTL := flag.Duration("proxy-ttl", config_web.DefaultProxyTTL, "how long the proxy serves configs without asking the upstream")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	var (
+		backend      config_web.Backend
+		instantiator config_web.Instantiator
+	)
+	if *proxyOf != "" {
+		proxy := config_web.NewProxy(&config_web.Client{Host: *proxyOf}).TTL(*proxyTTL)
+		backend, instantiator = proxy, proxy
+		slog.Info("configd: proxy mode", "upstream", *proxyOf)
+	} else {
+		cache := config.NewCache(*directory).SyncTimeout(*syncTimeout)
+		backend, instantiator = cache, cache
+	}
+	mux.HandleFunc(config_web.DefaultWebUrlInstantiate, func(w http.ResponseWriter, r *http.Request) {
+		if err := config_web.HandlerInstantiateVerbose(instantiator)(r.Context(), w, r); err != nil {
+			slog.Error("configd: instantiate", "err", err)
+		}
+	})
+	mux.HandleFunc(config_web.DefaultWebUrlGet, func(w http.ResponseWriter, r *http.Request) {
+		if err := config_web.HandlerGetVerbose(backend)(r.Context(), w, r); err != nil {
+			slog.Error("configd: get", "err", err)
+		}
+	})
+	mux.HandleFunc(config_web.DefaultWebUrlUpdate, func(w http.ResponseWriter, r *http.Request) {
+		if err := config_web.HandlerUpdateVerbose(backend)(r.Context(), w, r); err != nil {
+			slog.Error("configd: update", "err", err)
+		}
+	})
+	mux.HandleFunc(config_web.DefaultWebUrlWatch, func(w http.ResponseWriter, r *http.Request) {
+		if err := config_web.HandlerWatchVerbose(backend)(r.Context(), w, r); err != nil {
+			slog.Error("configd: watch", "err", err)
+		}
+	})
+
+	server := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("configd: listening", "addr", *addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("configd: serve", "err", err)
+		os.Exit(1)
+	}
+}
+
+// instantiate creates configs from a template, e.g. `configd instantiate -template tenant -namespace acme TenantID=acme`.
+func instantiate(args []string) {
+	flags := flag.NewFlagSet("instantiate", flag.ExitOnError)
+	directory := flags.String("dir", ".", "directory with the configs")
+	template := flags.String("template", "", "template name, a subdirectory of "+config.TemplatesDirectory)
+	namespace := flags.String("namespace", "", "subdirectory to create the configs in, the directory itself if empty")
+	_ = flags.Parse(args)
+
+	params := map[string]any{}
+	for _, arg := range flags.Args() {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "configd: instantiate, param %q is not key=value\n", arg)
+			os.Exit(2)
+		}
+		params[key] = value
+	}
+
+	configs, err := config.NewCache(*directory).Instantiate(context.Background(), *template, params, *namespace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, name := range configs {
+		fmt.Println(name)
+	}
+}
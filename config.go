@@ -148,7 +148,6 @@ func (cache *Cache) verboseGet(ctx context.Context, name string) (cfg *configVal
 	loaded := time.Now()
 	data, err := os.ReadFile(path)
 	if err != nil {
-		defer cache.lock.RUnlock()
 		return nil, false, err
 	}
 	config = &configValue{
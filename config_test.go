@@ -9,9 +9,11 @@ import (
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -245,6 +247,352 @@ func TestConfig_Sync(t *testing.T) {
 	}
 }
 
+func TestConfig_WarmStart(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.CopyFS(dir, os.DirFS("./testdata")); err != nil {
+		t.Fatalf("error while copying dir: %v", err)
+	}
+	warmPath := filepath.Join(t.TempDir(), "warm.json")
+
+	cache := config.NewCache(dir)
+	if _, err := config.Get[ConfigNameT](cache, "config_name"); err != nil {
+		t.Fatalf("error while getting 'config_name' %v", err)
+	}
+	if _, err := config.Get[map[string]string](cache, "config_key_value"); err != nil {
+		t.Fatalf("error while getting 'config_key_value' %v", err)
+	}
+	if err := cache.SaveWarmStart(warmPath); err != nil {
+		t.Fatalf("error while saving warm start: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "config_key_value.json")); err != nil {
+		t.Fatalf("error while removing config: %v", err)
+	}
+
+	restored := config.NewCache(dir)
+	if err := restored.LoadWarmStart(warmPath); err != nil {
+		t.Fatalf("error while loading warm start: %v", err)
+	}
+	if stats := restored.Stats(); len(stats.Configs) != 2 {
+		t.Fatalf("expected 2 restored configs, actual: %v", stats.Configs)
+	}
+	cfg, err := config.Get[ConfigNameT](restored, "config_name")
+	if err != nil {
+		t.Fatalf("error while getting 'config_name' %v", err)
+	}
+	if !reflect.DeepEqual(expectedConfigNameValue, cfg) {
+		t.Fatalf("expected: %v, actual: %v", expectedConfigNameValue, cfg)
+	}
+
+	if err := restored.Revalidate(t.Context()); err != nil {
+		t.Fatalf("error while revalidating: %v", err)
+	}
+	if stats := restored.Stats(); !reflect.DeepEqual(stats.Configs, []string{"config_name"}) {
+		t.Fatalf("expected removed config to be dropped, actual: %v", stats.Configs)
+	}
+
+	if err := config.NewCache(t.TempDir()).LoadWarmStart(warmPath); err == nil {
+		t.Fatalf("expected an error loading a snapshot of another directory")
+	}
+	relative, err := filepath.Rel(must(os.Getwd()), dir)
+	if err != nil {
+		t.Fatalf("error while making a relative path: %v", err)
+	}
+	if err := config.NewCache(relative).LoadWarmStart(warmPath); err != nil {
+		t.Fatalf("error while loading warm start into a relative directory: %v", err)
+	}
+
+	// The snapshot is saved on shutdown even without periodic saves.
+	shutdownPath := filepath.Join(t.TempDir(), "warm.json")
+	ctx, cancel := context.WithCancel(t.Context())
+	done, err := restored.WarmStart(ctx, shutdownPath, 0)
+	if err != nil {
+		t.Fatalf("error while starting warm start: %v", err)
+	}
+	cancel()
+	<-done
+	if err := config.NewCache(dir).LoadWarmStart(shutdownPath); err != nil {
+		t.Fatalf("error while loading warm start saved on shutdown: %v", err)
+	}
+}
+
+func TestConfig_Proxy(t *testing.T) {
+	t.Parallel()
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.CopyFS(dir, os.DirFS("./testdata")); err != nil {
+		t.Fatalf("error while copying dir: %v", err)
+	}
+
+	cache := config.NewCache(dir).SyncTimeout(0)
+	upstreamGets := atomic.Int64{}
+	upstreamMux := http.NewServeMux()
+	upstreamMux.HandleFunc(config_web.DefaultWebUrlGet, func(w http.ResponseWriter, r *http.Request) {
+		upstreamGets.Add(1)
+		time.Sleep(time.Millisecond * 50)
+		config_web.HandlerGet(cache)(w, r)
+	})
+	upstreamMux.HandleFunc(config_web.DefaultWebUrlUpdate, config_web.HandlerUpdate(cache))
+	upstreamWatches := atomic.Int64{}
+	upstreamMux.HandleFunc(config_web.DefaultWebUrlWatch, func(w http.ResponseWriter, r *http.Request) {
+		upstreamWatches.Add(1)
+		config_web.HandlerWatch(cache)(w, r)
+	})
+	upstream := httptest.NewServer(upstreamMux)
+	defer func() {
+		upstream.CloseClientConnections()
+		upstream.Close()
+	}()
+
+	proxy := config_web.NewProxy(&config_web.Client{Host: upstream.URL}).TTL(time.Minute)
+	proxyMux := http.NewServeMux()
+	proxyMux.HandleFunc(config_web.DefaultWebUrlGet, config_web.HandlerGet(proxy))
+	proxyMux.HandleFunc(config_web.DefaultWebUrlUpdate, config_web.HandlerUpdate(proxy))
+	proxyMux.HandleFunc(config_web.DefaultWebUrlWatch, config_web.HandlerWatch(proxy))
+	proxyServer := httptest.NewServer(proxyMux)
+	defer proxyServer.Close()
+
+	client := &config_web.Client{Host: proxyServer.URL}
+	wg := &sync.WaitGroup{}
+	for range 100 {
+		wg.Go(func() {
+			cfg, err := config_web.GetContext[ConfigNameT](t.Context(), client, "config_name")
+			if err != nil {
+				t.Errorf("error while getting 'config_name' %v", err)
+				return
+			}
+			if !reflect.DeepEqual(expectedConfigNameValue, cfg) {
+				t.Errorf("expected: %v, actual: %v", expectedConfigNameValue, cfg)
+			}
+		})
+	}
+	wg.Wait()
+	if gets := upstreamGets.Load(); gets != 1 {
+		t.Fatalf("expected a single upstream get, actual: %d", gets)
+	}
+
+	updated := *expectedConfigNameValue
+	updated.Integer = 42
+	if err := config_web.UpdateContext(t.Context(), client, "config_name", updated); err != nil {
+		t.Fatalf("error while updating 'config_name' %v", err)
+	}
+	if cfg, err := config.Get[ConfigNameT](cache, "config_name"); err != nil || cfg.Integer != 42 {
+		t.Fatalf("expected update forwarded to upstream, actual: %v, %v", cfg, err)
+	}
+	if cfg, err := config_web.GetContext[ConfigNameT](t.Context(), client, "config_name"); err != nil || cfg.Integer != 42 {
+		t.Fatalf("expected updated config from proxy, actual: %v, %v", cfg, err)
+	}
+	if gets := upstreamGets.Load(); gets != 1 {
+		t.Fatalf("expected no upstream get after update, actual: %d", gets)
+	}
+
+	// Unknown configs leave nothing behind in the proxy.
+	if _, err := client.GetContext(t.Context(), "config_name_not_found"); err == nil {
+		t.Fatalf("expected an error for an unknown config")
+	}
+	if stats := proxy.Stats(); !reflect.DeepEqual(stats.Configs, []string{"config_name"}) {
+		t.Fatalf("expected only 'config_name' cached, actual: %v", stats.Configs)
+	}
+
+	// All the watches share a single upstream watch.
+	current, err := client.GetContext(t.Context(), "config_name")
+	if err != nil {
+		t.Fatalf("error while getting 'config_name' %v", err)
+	}
+	revision := config_web.Revision(current)
+	updated.Integer = 43
+	for range 50 {
+		wg.Go(func() {
+			data, newRevision, err := client.WatchContext(t.Context(), "config_name", revision)
+			if err != nil {
+				t.Errorf("error while watching 'config_name' %v", err)
+				return
+			}
+			if newRevision == revision || !reflect.DeepEqual(data, must(json.Marshal(updated))) {
+				t.Errorf("expected watch to return the update, actual: %s", data)
+			}
+		})
+	}
+	time.Sleep(time.Millisecond * 200)
+	if err := os.WriteFile(filepath.Join(dir, "config_name.json"), must(json.Marshal(updated)), 0644); err != nil {
+		t.Fatalf("error while writing config: %v", err)
+	}
+	wg.Wait()
+	if watches := upstreamWatches.Load(); watches > 2 {
+		t.Fatalf("expected a single upstream watch at a time, actual: %d", watches)
+	}
+}
+
+func TestConfig_ClientInit(t *testing.T) {
+	t.Parallel()
+	cache := config.NewCache("./testdata")
+	server := httptest.NewServer(http.HandlerFunc(config_web.HandlerGet(cache)))
+	defer server.Close()
+
+	// Racing first calls on a fresh client used to leave the read lock held and deadlock the rest.
+	for range 100 {
+		client := &config_web.Client{Host: server.URL}
+		start := make(chan struct{})
+		wg := &sync.WaitGroup{}
+		for range 50 {
+			wg.Go(func() {
+				<-start
+				if _, err := config_web.GetContext[ConfigNameT](t.Context(), client, "config_name"); err != nil {
+					t.Errorf("error while getting 'config_name' %v", err)
+				}
+			})
+		}
+		close(start)
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second * 10):
+			t.Fatalf("concurrent first calls of a client deadlocked")
+		}
+	}
+}
+
+func TestConfig_Instantiate(t *testing.T) {
+	t.Parallel()
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("error while creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.CopyFS(dir, os.DirFS("./testdata")); err != nil {
+		t.Fatalf("error while copying dir: %v", err)
+	}
+	cache := config.NewCache(dir)
+
+	if _, err := cache.Instantiate(t.Context(), "tenant", map[string]any{"TenantID": "acme"}, "acme"); !errors.Is(err, config.ErrInvalidInstantiate) {
+		t.Fatalf("expected an error for a missing template param")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "acme", "limits.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing created on a failed instantiate, actual: %v", err)
+	}
+
+	params := map[string]any{"TenantID": "acme", "Plan": "pro"}
+	configs, err := cache.Instantiate(t.Context(), "tenant", params, "acme")
+	if err != nil {
+		t.Fatalf("error while instantiating 'tenant' %v", err)
+	}
+	if expected := []string{"acme/billing", "acme/limits"}; !reflect.DeepEqual(expected, configs) {
+		t.Fatalf("expected: %v, actual: %v", expected, configs)
+	}
+	billing, err := config.Get[map[string]string](cache, "acme/billing")
+	if err != nil {
+		t.Fatalf("error while getting 'acme/billing' %v", err)
+	}
+	if expected := map[string]string{"tenant_id": "acme", "plan": "pro"}; !maps.Equal(expected, *billing) {
+		t.Fatalf("expected: %v, actual: %v", expected, *billing)
+	}
+
+	if _, err := cache.Instantiate(t.Context(), "tenant", params, "acme"); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected an already exists error, actual: %v", err)
+	}
+	if _, err := cache.Instantiate(t.Context(), "tenant", params, "../acme"); !errors.Is(err, config.ErrInvalidInstantiate) {
+		t.Fatalf("expected an error for a namespace outside the directory, actual: %v", err)
+	}
+
+	// Params are escaped, so quotes neither break the json nor inject keys.
+	for namespace, tenantID := range map[string]string{"obrien": `O"Brien\`, "inject": `x","plan":"enterprise`} {
+		if _, err := cache.Instantiate(t.Context(), "tenant", map[string]any{"TenantID": tenantID, "Plan": "free"}, namespace); err != nil {
+			t.Fatalf("error while instantiating 'tenant' into %s %v", namespace, err)
+		}
+		billing, err := config.Get[map[string]string](cache, namespace+"/billing")
+		if err != nil {
+			t.Fatalf("error while getting '%s/billing' %v", namespace, err)
+		}
+		if expected := map[string]string{"tenant_id": tenantID, "plan": "free"}; !maps.Equal(expected, *billing) {
+			t.Fatalf("expected: %v, actual: %v", expected, *billing)
+		}
+	}
+
+	// An empty namespace is the directory itself.
+	if configs, err := cache.Instantiate(t.Context(), "tenant", params, ""); err != nil || !reflect.DeepEqual(configs, []string{"billing", "limits"}) {
+		t.Fatalf("expected configs created in the directory, actual: %v, %v", configs, err)
+	}
+
+	// Template names are plain paths, not patterns matching every template.
+	if err := os.MkdirAll(filepath.Join(dir, config.TemplatesDirectory, "extra"), 0777); err != nil {
+		t.Fatalf("error while creating template dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, config.TemplatesDirectory, "extra", "billing.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatalf("error while writing template: %v", err)
+	}
+	for _, name := range []string{"*", "[", "tenan?"} {
+		if _, err := cache.Instantiate(t.Context(), name, params, "patterns"); !errors.Is(err, config.ErrInvalidInstantiate) {
+			t.Fatalf("expected an error for template name %q, actual: %v", name, err)
+		}
+	}
+
+	// Rendering into the templates directory would create new templates.
+	for _, namespace := range []string{config.TemplatesDirectory, config.TemplatesDirectory + "/evil", "./" + config.TemplatesDirectory + "/evil"} {
+		if _, err := cache.Instantiate(t.Context(), "tenant", params, namespace); !errors.Is(err, config.ErrInvalidInstantiate) {
+			t.Fatalf("expected an error for namespace %q, actual: %v", namespace, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, config.TemplatesDirectory, "evil")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing created in the templates directory, actual: %v", err)
+	}
+
+	handler := config_web.HandlerInstantiate(cache)
+	for _, tc := range []struct {
+		body     string
+		expected int
+	}{
+		{`{"template": "tenant", "namespace": "globex", "params": {"TenantID": "globex", "Plan": "pro"}}`, http.StatusOK},
+		{`{"template": "tenant", "namespace": "globex", "params": {"TenantID": "globex", "Plan": "pro"}}`, http.StatusConflict},
+		{`{"template": "tenant", "namespace": "initech", "params": {"TenantID": "initech"}}`, http.StatusBadRequest},
+		{`{"template": "missing", "namespace": "initech", "params": {}}`, http.StatusNotFound},
+	} {
+		rw := httptest.NewRecorder()
+		handler(rw, httptest.NewRequest(http.MethodPost, config_web.DefaultWebUrlInstantiate, strings.NewReader(tc.body)))
+		if rw.Code != tc.expected {
+			t.Fatalf("expected status %d for %s, actual: %d %s", tc.expected, tc.body, rw.Code, rw.Body.String())
+		}
+	}
+
+	// A proxy forwards instantiating to its upstream, keeping the rejections.
+	upstreamMux := http.NewServeMux()
+	upstreamMux.HandleFunc(config_web.DefaultWebUrlInstantiate, config_web.HandlerInstantiate(cache))
+	upstream := httptest.NewServer(upstreamMux)
+	defer upstream.Close()
+	proxyMux := http.NewServeMux()
+	proxyMux.HandleFunc(config_web.DefaultWebUrlInstantiate, config_web.HandlerInstantiate(config_web.NewProxy(&config_web.Client{Host: upstream.URL})))
+	proxyServer := httptest.NewServer(proxyMux)
+	defer proxyServer.Close()
+
+	client := &config_web.Client{Host: proxyServer.URL}
+	configs, err = client.InstantiateContext(t.Context(), "tenant", map[string]any{"TenantID": "umbrella", "Plan": "pro"}, "umbrella")
+	if err != nil {
+		t.Fatalf("error while instantiating through the proxy %v", err)
+	}
+	if expected := []string{"umbrella/billing", "umbrella/limits"}; !reflect.DeepEqual(expected, configs) {
+		t.Fatalf("expected: %v, actual: %v", expected, configs)
+	}
+	if _, err := client.InstantiateContext(t.Context(), "tenant", map[string]any{"TenantID": "umbrella", "Plan": "pro"}, "umbrella"); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected an already exists error through the proxy, actual: %v", err)
+	}
+	if _, err := client.InstantiateContext(t.Context(), "tenant", map[string]any{}, "hooli"); !errors.Is(err, config.ErrInvalidInstantiate) {
+		t.Fatalf("expected an invalid instantiate error through the proxy, actual: %v", err)
+	}
+}
+
 func must[T any](v T, err error) T {
 	if err != nil {
 		panic(err)
@@ -0,0 +1,254 @@
+package config_web
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProxyTTL          = time.Second * 5
+	DefaultProxyFetchTimeout = time.Second * 30
+)
+
+// Proxy serves configs of the upstream config_web server from memory. All the watches of a config share a single
+// upstream watch, which also keeps the config fresh for reads. Configs nobody watches are refetched after the TTL,
+// concurrent reads sharing one upstream request. Updates are forwarded to the upstream.
+type Proxy struct {
+	upstream     *Client
+	ttl          time.Duration
+	fetchTimeout time.Duration
+
+	lock    sync.Mutex
+	configs map[string]*proxyValue
+}
+
+type proxyValue struct {
+	Raw        []byte
+	Revision   string
+	LastUpdate time.Time
+	Err        error
+	fetch      chan struct{} // not nil while an upstream request is in flight
+	changed    chan struct{} // closed and replaced on every change or upstream watch error
+	watchers   int
+	watching   bool
+}
+
+func NewProxy(upstream *Client) *Proxy {
+	return &Proxy{
+		upstream:     upstream,
+		ttl:          DefaultProxyTTL,
+		fetchTimeout: DefaultProxyFetchTimeout,
+		configs:      make(map[string]*proxyValue),
+	}
+}
+
+func (proxy *Proxy) TTL(duration time.Duration) *Proxy {
+	proxy.ttl = duration
+	return proxy
+}
+
+func (proxy *Proxy) FetchTimeout(duration time.Duration) *Proxy {
+	proxy.fetchTimeout = duration
+	return proxy
+}
+
+func (proxy *Proxy) GetContext(ctx context.Context, name string) ([]byte, error) {
+	proxy.lock.Lock()
+	if ctx.Err() != nil {
+		proxy.lock.Unlock()
+		return nil, ctx.Err()
+	}
+	cfg := proxy.value(name)
+	if cfg.Raw != nil && (cfg.watching || time.Since(cfg.LastUpdate) < proxy.ttl) {
+		proxy.lock.Unlock()
+		return cfg.Raw, nil
+	}
+	fetch := cfg.fetch
+	if fetch == nil {
+		fetch = make(chan struct{})
+		cfg.fetch = fetch
+		go proxy.fetch(name, cfg, fetch)
+	}
+	proxy.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-fetch:
+	}
+
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	// A stale config is better than none while the upstream is unavailable.
+	if cfg.Raw != nil {
+		return cfg.Raw, nil
+	}
+	return nil, cfg.Err
+}
+
+// WatchContext waits until the revision of the config differs from the given one, an empty revision returns
+// the config as soon as it is known.
+func (proxy *Proxy) WatchContext(ctx context.Context, name string, revision string) ([]byte, string, error) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	cfg := proxy.value(name)
+	cfg.watchers++
+	defer func() { cfg.watchers-- }()
+	if !cfg.watching {
+		cfg.watching = true
+		go proxy.watch(name, cfg)
+	}
+
+	for {
+		if cfg.Raw != nil && cfg.Revision != revision {
+			return cfg.Raw, cfg.Revision, nil
+		}
+		if cfg.Raw == nil && cfg.Err != nil {
+			return nil, "", cfg.Err
+		}
+		changed := cfg.changed
+		proxy.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			proxy.lock.Lock()
+			return nil, "", ctx.Err()
+		case <-changed:
+		}
+		proxy.lock.Lock()
+	}
+}
+
+func (proxy *Proxy) UpdateContext(ctx context.Context, name string, data []byte) error {
+	if err := proxy.upstream.UpdateContext(ctx, name, data); err != nil {
+		return err
+	}
+
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	proxy.set(proxy.value(name), data)
+	return nil
+}
+
+// Instantiate forwards creating the configs of a template to the upstream.
+func (proxy *Proxy) Instantiate(ctx context.Context, template string, params map[string]any, namespace string) ([]string, error) {
+	return proxy.upstream.InstantiateContext(ctx, template, params, namespace)
+}
+
+func (proxy *Proxy) Get(name string) ([]byte, error) {
+	return proxy.GetContext(context.Background(), name)
+}
+
+func (proxy *Proxy) Update(name string, data []byte) error {
+	return proxy.UpdateContext(context.Background(), name, data)
+}
+
+type ProxyStats struct {
+	Upstream string
+	Configs  []string
+}
+
+func (proxy *Proxy) Stats() ProxyStats {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	result := ProxyStats{
+		Upstream: proxy.upstream.Host,
+	}
+	for configName := range proxy.configs {
+		result.Configs = append(result.Configs, configName)
+	}
+	return result
+}
+
+// fetch is detached from the requests waiting for it, one of them giving up must not fail the rest.
+func (proxy *Proxy) fetch(name string, cfg *proxyValue, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), proxy.fetchTimeout)
+	defer cancel()
+
+	started := time.Now()
+	data, err := proxy.upstream.GetContext(ctx, name)
+
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	defer close(done)
+	cfg.fetch = nil
+	if err != nil {
+		slog.Error("config_web: proxy, upstream get", "err", err, "config", name)
+		cfg.Err = err
+		proxy.forget(name, cfg)
+		return
+	}
+	// An update forwarded while the request was in flight is newer than its response.
+	if cfg.LastUpdate.After(started) {
+		return
+	}
+	proxy.set(cfg, data)
+}
+
+// watch keeps a single upstream watch of the config while anybody watches it through the proxy.
+func (proxy *Proxy) watch(name string, cfg *proxyValue) {
+	for {
+		proxy.lock.Lock()
+		if cfg.watchers == 0 {
+			cfg.watching = false
+			proxy.forget(name, cfg)
+			proxy.lock.Unlock()
+			return
+		}
+		revision := cfg.Revision
+		proxy.lock.Unlock()
+
+		// The upstream answers within DefaultWatchTimeout, the rest is slack for the network.
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultWatchTimeout+proxy.fetchTimeout)
+		data, _, err := proxy.upstream.WatchContext(ctx, name, revision)
+		cancel()
+
+		proxy.lock.Lock()
+		switch {
+		case err != nil:
+			slog.Error("config_web: proxy, upstream watch", "err", err, "config", name)
+			cfg.Err = err
+			close(cfg.changed)
+			cfg.changed = make(chan struct{})
+		case data != nil:
+			proxy.set(cfg, data)
+		}
+		proxy.lock.Unlock()
+
+		if err != nil {
+			time.Sleep(DefaultWatchPollInterval)
+		}
+	}
+}
+
+// value returns the config entry, creating it if needed. The lock must be held.
+func (proxy *Proxy) value(name string) *proxyValue {
+	cfg, ok := proxy.configs[name]
+	if !ok {
+		cfg = &proxyValue{changed: make(chan struct{})}
+		proxy.configs[name] = cfg
+	}
+	return cfg
+}
+
+// set stores the config and wakes up its watchers. The lock must be held.
+func (proxy *Proxy) set(cfg *proxyValue, data []byte) {
+	revision := Revision(data)
+	cfg.Raw = data
+	cfg.LastUpdate = time.Now()
+	cfg.Err = nil
+	if revision != cfg.Revision {
+		cfg.Revision = revision
+		close(cfg.changed)
+		cfg.changed = make(chan struct{})
+	}
+}
+
+// forget drops the entry of a config the upstream failed to provide, so unknown names don't pile up.
+// The lock must be held.
+func (proxy *Proxy) forget(name string, cfg *proxyValue) {
+	if cfg.Raw == nil && cfg.watchers == 0 && !cfg.watching && cfg.fetch == nil && proxy.configs[name] == cfg {
+		delete(proxy.configs, name)
+	}
+}
@@ -7,10 +7,18 @@ import (
 	"fmt"
 	"github.com/kittenbark/config"
 	"io"
+	"io/fs"
 	"net/http"
+	"time"
 )
 
-func HandlerGetVerbose(cache *config.Cache) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+// Backend is what the handlers serve configs from, e.g. *config.Cache or *Proxy.
+type Backend interface {
+	GetContext(ctx context.Context, name string) ([]byte, error)
+	UpdateContext(ctx context.Context, name string, data []byte) error
+}
+
+func HandlerGetVerbose(cache Backend) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 	type RequestSchema struct {
 		Config string `json:"config"`
 	}
@@ -33,7 +41,7 @@ func HandlerGetVerbose(cache *config.Cache) func(ctx context.Context, rw http.Re
 			return fmt.Errorf("config_web: get, request config name not found")
 		}
 
-		resultData, err := cache.Get(configName)
+		resultData, err := cache.GetContext(ctx, configName)
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			data, _ := json.Marshal(ResponseError{Error: err.Error()})
@@ -49,7 +57,7 @@ func HandlerGetVerbose(cache *config.Cache) func(ctx context.Context, rw http.Re
 	}
 }
 
-func HandlerUpdateVerbose(cache *config.Cache) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+func HandlerUpdateVerbose(cache Backend) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 	type RequestSchema struct {
 		Config string `json:"config"`
 	}
@@ -86,7 +94,7 @@ func HandlerUpdateVerbose(cache *config.Cache) func(ctx context.Context, rw http
 			return fmt.Errorf("config_web: update, error parsing body %v", errors.Join(err, respErr))
 		}
 
-		if err := cache.Update(configName, bodyData); err != nil {
+		if err := cache.UpdateContext(ctx, configName, bodyData); err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			data, _ := json.Marshal(ResponseError{Error: err.Error()})
 			_, respErr := rw.Write(data)
@@ -98,16 +106,151 @@ func HandlerUpdateVerbose(cache *config.Cache) func(ctx context.Context, rw http
 	}
 }
 
-func HandlerGet(cache *config.Cache) func(w http.ResponseWriter, r *http.Request) {
+// Watcher is implemented by backends notified about config changes, e.g. *Proxy. Other backends are polled
+// every DefaultWatchPollInterval.
+type Watcher interface {
+	WatchContext(ctx context.Context, name string, revision string) ([]byte, string, error)
+}
+
+// HandlerWatchVerbose long-polls a config: it responds with the config once its revision differs from the
+// "revision" query param, or with 304 Not Modified after DefaultWatchTimeout.
+func HandlerWatchVerbose(cache Backend) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+	type ResponseError struct {
+		Error string `json:"error"`
+	}
+
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		configName := req.URL.Query().Get("config")
+		if configName == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			data, _ := json.Marshal(ResponseError{Error: "config name not found"})
+			_, respErr := rw.Write(data)
+			return fmt.Errorf("config_web: watch, request config name not found %v", respErr)
+		}
+
+		watchCtx, cancel := context.WithTimeout(ctx, DefaultWatchTimeout)
+		defer cancel()
+		resultData, revision, err := watch(watchCtx, cache, configName, req.URL.Query().Get("revision"))
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			rw.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			data, _ := json.Marshal(ResponseError{Error: err.Error()})
+			_, respErr := rw.Write(data)
+			return fmt.Errorf("config_web: watch, error watching config %v", errors.Join(err, respErr))
+		}
+
+		rw.Header().Set(HeaderRevision, revision)
+		rw.WriteHeader(http.StatusOK)
+		if _, respErr := rw.Write(resultData); respErr != nil {
+			return fmt.Errorf("config_web: watch, error making response %v", respErr)
+		}
+		return nil
+	}
+}
+
+func watch(ctx context.Context, cache Backend, name string, revision string) ([]byte, string, error) {
+	if watcher, ok := cache.(Watcher); ok {
+		return watcher.WatchContext(ctx, name, revision)
+	}
+
+	ticker := time.NewTicker(DefaultWatchPollInterval)
+	defer ticker.Stop()
+	for {
+		data, err := cache.GetContext(ctx, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if current := Revision(data); current != revision {
+			return data, current, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Instantiator creates configs from templates, e.g. *config.Cache or *Proxy forwarding to its upstream.
+type Instantiator interface {
+	Instantiate(ctx context.Context, template string, params map[string]any, namespace string) ([]string, error)
+}
+
+func HandlerInstantiateVerbose(cache Instantiator) func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+	type RequestSchema struct {
+		Template  string         `json:"template"`
+		Namespace string         `json:"namespace"`
+		Params    map[string]any `json:"params"`
+	}
+	type ResponseSchema struct {
+		Configs []string `json:"configs"`
+	}
+	type ResponseError struct {
+		Error string `json:"error"`
+	}
+
+	return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		var body RequestSchema
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20 /*1 MB*/)).Decode(&body); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			data, _ := json.Marshal(ResponseError{Error: err.Error()})
+			_, respErr := rw.Write(data)
+			return fmt.Errorf("config_web: instantiate, error parsing request %v", errors.Join(err, respErr))
+		}
+
+		configs, err := cache.Instantiate(ctx, body.Template, body.Params, body.Namespace)
+		if err != nil {
+			switch {
+			case errors.Is(err, config.ErrInvalidInstantiate):
+				rw.WriteHeader(http.StatusBadRequest)
+			case errors.Is(err, fs.ErrNotExist):
+				rw.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, fs.ErrExist):
+				rw.WriteHeader(http.StatusConflict)
+			default:
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			data, _ := json.Marshal(ResponseError{Error: err.Error()})
+			_, respErr := rw.Write(data)
+			return fmt.Errorf("config_web: instantiate, error instantiating template %v", errors.Join(err, respErr))
+		}
+
+		data, _ := json.Marshal(ResponseSchema{Configs: configs})
+		rw.WriteHeader(http.StatusOK)
+		if _, respErr := rw.Write(data); respErr != nil {
+			return fmt.Errorf("config_web: instantiate, error making response %v", respErr)
+		}
+		return nil
+	}
+}
+
+func HandlerGet(cache Backend) func(w http.ResponseWriter, r *http.Request) {
 	handler := HandlerGetVerbose(cache)
 	return func(w http.ResponseWriter, r *http.Request) {
 		_ = handler(context.Background(), w, r)
 	}
 }
 
-func HandlerUpdate(cache *config.Cache) func(w http.ResponseWriter, r *http.Request) {
+func HandlerUpdate(cache Backend) func(w http.ResponseWriter, r *http.Request) {
 	handler := HandlerUpdateVerbose(cache)
 	return func(w http.ResponseWriter, r *http.Request) {
 		_ = handler(context.Background(), w, r)
 	}
 }
+
+func HandlerInstantiate(cache Instantiator) func(w http.ResponseWriter, r *http.Request) {
+	handler := HandlerInstantiateVerbose(cache)
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = handler(context.Background(), w, r)
+	}
+}
+
+func HandlerWatch(cache Backend) func(w http.ResponseWriter, r *http.Request) {
+	handler := HandlerWatchVerbose(cache)
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = handler(r.Context(), w, r)
+	}
+}
@@ -3,21 +3,38 @@ package config_web
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/kittenbark/config"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	DefaultWebUrlGet    = "/v1/config/get"
-	DefaultWebUrlUpdate = "/v1/config/update"
+	DefaultWebUrlGet         = "/v1/config/get"
+	DefaultWebUrlUpdate      = "/v1/config/update"
+	DefaultWebUrlInstantiate = "/v1/config/instantiate"
+	DefaultWebUrlWatch       = "/v1/config/watch"
+
+	HeaderRevision           = "X-Config-Revision"
+	DefaultWatchTimeout      = time.Second * 30
+	DefaultWatchPollInterval = time.Second
 )
 
+// Revision identifies the content of a config, the server and its clients compute it the same way.
+func Revision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
 func Get[T any](client *Client, name string, reqMod ...func(r *http.Request)) (*T, error) {
 	return GetContext[T](context.Background(), client, name, reqMod...)
 }
@@ -48,10 +65,12 @@ func UpdateContext[T any](ctx context.Context, client *Client, name string, val
 }
 
 type Client struct {
-	Host      string
-	UrlGet    string // default: DefaultWebUrlGet
-	UrlUpdate string // default: DefaultWebUrlUpdate
-	Client    *http.Client
+	Host           string
+	UrlGet         string // default: DefaultWebUrlGet
+	UrlUpdate      string // default: DefaultWebUrlUpdate
+	UrlWatch       string // default: DefaultWebUrlWatch
+	UrlInstantiate string // default: DefaultWebUrlInstantiate
+	Client         *http.Client
 
 	lock        sync.RWMutex
 	initialized bool
@@ -119,9 +138,102 @@ func (client *Client) UpdateContext(ctx context.Context, name string, data []byt
 	return nil
 }
 
+// WatchContext long-polls the config until its revision differs from the given one, an empty revision returns
+// the config right away. If the server times out without a change, data is nil and the revision is the given one.
+func (client *Client) WatchContext(ctx context.Context, name string, revision string, reqMod ...func(r *http.Request)) ([]byte, string, error) {
+	client.init()
+
+	endpoint, err := url.Parse(client.Host)
+	if err != nil {
+		return nil, "", fmt.Errorf("config_web: watch, request url build error %w", err)
+	}
+	endpoint = endpoint.JoinPath(client.UrlWatch)
+	q := endpoint.Query()
+	q.Add("config", name)
+	q.Add("revision", revision)
+	endpoint.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("config_web: watch, request build error %w", err)
+	}
+	for _, mod := range reqMod {
+		mod(req)
+	}
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("config_web: watch, request error %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, revision, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("config_web: watch, request status code %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("config_web: watch, read response body error %w", err)
+	}
+	return body, Revision(body), nil
+}
+
+// InstantiateContext creates the configs of a template on the server, see config.Cache.Instantiate. Rejections are
+// reported as the errors Instantiate returns for them: config.ErrInvalidInstantiate, fs.ErrNotExist and fs.ErrExist.
+func (client *Client) InstantiateContext(ctx context.Context, template string, params map[string]any, namespace string, reqMod ...func(r *http.Request)) ([]string, error) {
+	client.init()
+
+	endpoint, err := url.Parse(client.Host)
+	if err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, request url build error %w", err)
+	}
+	endpoint = endpoint.JoinPath(client.UrlInstantiate)
+	data, err := json.Marshal(map[string]any{"template": template, "namespace": namespace, "params": params})
+	if err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, request build error %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, request build error %w", err)
+	}
+	for _, mod := range reqMod {
+		mod(req)
+	}
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, request error %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, read response body error %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		return nil, fmt.Errorf("config_web: instantiate, %w %s", config.ErrInvalidInstantiate, body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("config_web: instantiate, %w %s", fs.ErrNotExist, body)
+	case http.StatusConflict:
+		return nil, fmt.Errorf("config_web: instantiate, %w %s", fs.ErrExist, body)
+	default:
+		return nil, fmt.Errorf("config_web: instantiate, request status code %s", resp.Status)
+	}
+
+	var result struct {
+		Configs []string `json:"configs"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("config_web: instantiate, parse response body error %w", err)
+	}
+	return result.Configs, nil
+}
+
 func (client *Client) init() *Client {
 	client.lock.RLock()
 	if client.initialized {
+		client.lock.RUnlock()
 		return client
 	}
 	client.lock.RUnlock()
@@ -135,6 +247,12 @@ func (client *Client) init() *Client {
 	client.initialized = true
 	client.UrlGet = DefaultWebUrlGet
 	client.UrlUpdate = DefaultWebUrlUpdate
+	if client.UrlWatch == "" {
+		client.UrlWatch = DefaultWebUrlWatch
+	}
+	if client.UrlInstantiate == "" {
+		client.UrlInstantiate = DefaultWebUrlInstantiate
+	}
 	client.Client = http.DefaultClient
 	return client
 }
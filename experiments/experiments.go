@@ -0,0 +1,372 @@
+package experiments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kittenbark/config"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Buckets is the size of the hashing space units are spread over, traffic ranges are mapped onto it.
+const Buckets = 10_000
+
+var (
+	ErrUnknownExperiment = errors.New("experiments: unknown experiment")
+	ErrInvalidSalt       = errors.New("experiments: invalid salt")
+	ErrLayerOverlap      = errors.New("experiments: traffic overlaps within a layer")
+)
+
+// File is the json schema of the experiments config, e.g.
+//
+//	{
+//	  "layers": {"checkout": {"salt": "checkout-v1"}},
+//	  "experiments": {
+//	    "button_color": {
+//	      "salt": "button-color-v1",
+//	      "layer": "checkout",
+//	      "traffic": {"from": 0, "to": 50},
+//	      "variants": [{"name": "control", "weight": 1}, {"name": "blue", "weight": 1, "value": {"color": "#00f"}}]
+//	    }
+//	  }
+//	}
+type File struct {
+	Layers      map[string]Layer      `json:"layers"`
+	Experiments map[string]Experiment `json:"experiments"`
+}
+
+// Layer makes its experiments mutually exclusive: they share one bucket per unit and must not overlap in traffic.
+type Layer struct {
+	Salt string `json:"salt"`
+}
+
+type Experiment struct {
+	Salt     string    `json:"salt"`
+	Layer    string    `json:"layer,omitempty"`
+	Disabled bool      `json:"disabled,omitempty"`
+	Traffic  *Traffic  `json:"traffic,omitempty"` // default: all the traffic
+	Variants []Variant `json:"variants"`
+}
+
+// Traffic is the [From, To) range of traffic in percents. Growing the range keeps already included units in.
+type Traffic struct {
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+}
+
+type Variant struct {
+	Name   string          `json:"name"`
+	Weight float64         `json:"weight"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+type Assignment struct {
+	Experiment string
+	Unit       string
+	Included   bool // false if the unit is outside the experiment traffic or the experiment is disabled
+	Variant    string
+	Value      json.RawMessage
+}
+
+type Exposure struct {
+	Experiment string
+	Layer      string
+	Unit       string
+	Variant    string
+	Time       time.Time
+}
+
+// Sink receives an exposure for every assignment of an included unit. It is called synchronously from
+// Assign, so a slow sink slows down assignments: buffer or hand exposures off in the sink itself.
+// Errors are logged and never fail the assignment.
+type Sink interface {
+	Expose(ctx context.Context, exposure Exposure) error
+}
+
+type SinkFunc func(ctx context.Context, exposure Exposure) error
+
+func (f SinkFunc) Expose(ctx context.Context, exposure Exposure) error {
+	return f(ctx, exposure)
+}
+
+// Store keeps assignments sticky when variant weights change on reload, hashing alone only guarantees
+// stickiness while the allocation stays the same.
+type Store interface {
+	Load(ctx context.Context, experiment string, unit string) (variant string, ok bool, err error)
+	Save(ctx context.Context, experiment string, unit string, variant string) error
+}
+
+func NewAssigner(cache *config.Cache, name string) *Assigner {
+	return &Assigner{
+		cache: cache,
+		name:  name,
+	}
+}
+
+type Assigner struct {
+	cache *config.Cache
+	name  string
+	sink  Sink
+	store Store
+
+	lock      sync.Mutex
+	validated *File
+	rejected  string // error of the last rejected reload, logged once
+}
+
+func (assigner *Assigner) Sink(sink Sink) *Assigner {
+	assigner.sink = sink
+	return assigner
+}
+
+func (assigner *Assigner) Store(store Store) *Assigner {
+	assigner.store = store
+	return assigner
+}
+
+func (assigner *Assigner) Assign(experiment string, unit string) (Assignment, error) {
+	return assigner.AssignContext(context.Background(), experiment, unit)
+}
+
+func (assigner *Assigner) AssignContext(ctx context.Context, experiment string, unit string) (Assignment, error) {
+	file, err := assigner.load(ctx)
+	if err != nil {
+		return Assignment{}, err
+	}
+	exp, ok := file.Experiments[experiment]
+	if !ok {
+		return Assignment{}, fmt.Errorf("%w %s", ErrUnknownExperiment, experiment)
+	}
+
+	result := Assignment{Experiment: experiment, Unit: unit}
+	if exp.Disabled || !exp.includes(file.Layers, unit) {
+		return result, nil
+	}
+
+	variant, err := assigner.variant(ctx, experiment, exp, unit)
+	if err != nil {
+		return Assignment{}, err
+	}
+	result.Included = true
+	result.Variant = variant.Name
+	result.Value = variant.Value
+
+	if assigner.sink != nil {
+		exposure := Exposure{
+			Experiment: experiment,
+			Layer:      exp.Layer,
+			Unit:       unit,
+			Variant:    variant.Name,
+			Time:       time.Now(),
+		}
+		if err := assigner.sink.Expose(ctx, exposure); err != nil {
+			slog.Error("experiments: exposure sink", "err", err, "experiment", experiment, "unit", unit)
+		}
+	}
+	return result, nil
+}
+
+func (assigner *Assigner) variant(ctx context.Context, name string, exp Experiment, unit string) (Variant, error) {
+	// The salt is a part of the key, so changing it deliberately reshuffles everyone.
+	key := name + ":" + exp.Salt
+	if assigner.store != nil {
+		stored, ok, err := assigner.store.Load(ctx, key, unit)
+		if err != nil {
+			return Variant{}, fmt.Errorf("experiments: store load %w", err)
+		}
+		if ok {
+			for _, variant := range exp.Variants {
+				if variant.Name == stored {
+					return variant, nil
+				}
+			}
+		}
+	}
+
+	variant := exp.pick(unit)
+	if assigner.store != nil {
+		if err := assigner.store.Save(ctx, key, unit, variant.Name); err != nil {
+			return Variant{}, fmt.Errorf("experiments: store save %w", err)
+		}
+	}
+	return variant, nil
+}
+
+// load returns the current experiments config, validating it once per reload of the cache. A reload that fails
+// to decode or validate is logged and the last valid config keeps being served, so a bad push doesn't stop assignments.
+func (assigner *Assigner) load(ctx context.Context) (*File, error) {
+	file, err := config.GetContext[File](ctx, assigner.cache, assigner.name)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	assigner.lock.Lock()
+	defer assigner.lock.Unlock()
+	if err == nil && assigner.validated == file {
+		return file, nil
+	}
+	if err == nil {
+		err = file.Validate()
+	}
+	if err == nil {
+		assigner.validated = file
+		assigner.rejected = ""
+		return file, nil
+	}
+	if assigner.validated == nil {
+		return nil, err
+	}
+	if assigner.rejected != err.Error() {
+		assigner.rejected = err.Error()
+		slog.Error("experiments: reload rejected, serving the last valid config", "err", err, "config", assigner.name)
+	}
+	return assigner.validated, nil
+}
+
+func (file *File) Validate() error {
+	type segment struct {
+		experiment string
+		traffic    Traffic
+	}
+	// Experiments and layers sharing a salt would get the same buckets for every unit, correlating their assignments.
+	salts := map[string]string{}
+	checkSalt := func(owner string, salt string) error {
+		if salt == "" {
+			return fmt.Errorf("%w, %s has an empty salt", ErrInvalidSalt, owner)
+		}
+		if other, ok := salts[salt]; ok {
+			return fmt.Errorf("%w, %s and %s share salt %q", ErrInvalidSalt, other, owner, salt)
+		}
+		salts[salt] = owner
+		return nil
+	}
+	for name, layer := range file.Layers {
+		if err := checkSalt("layer "+name, layer.Salt); err != nil {
+			return err
+		}
+	}
+	layers := map[string][]segment{}
+	for name, exp := range file.Experiments {
+		if err := checkSalt("experiment "+name, exp.Salt); err != nil {
+			return err
+		}
+		if len(exp.Variants) == 0 {
+			return fmt.Errorf("experiments: %s, no variants", name)
+		}
+		seen := map[string]bool{}
+		total := 0.0
+		for _, variant := range exp.Variants {
+			if variant.Name == "" || seen[variant.Name] {
+				return fmt.Errorf("experiments: %s, empty or duplicate variant name %q", name, variant.Name)
+			}
+			if variant.Weight < 0 {
+				return fmt.Errorf("experiments: %s, negative weight of variant %s", name, variant.Name)
+			}
+			seen[variant.Name] = true
+			total += variant.Weight
+		}
+		if total <= 0 {
+			return fmt.Errorf("experiments: %s, variant weights sum up to zero", name)
+		}
+
+		traffic := exp.traffic()
+		if traffic.From < 0 || traffic.To > 100 || traffic.From > traffic.To {
+			return fmt.Errorf("experiments: %s, invalid traffic [%v, %v)", name, traffic.From, traffic.To)
+		}
+		if exp.Layer == "" {
+			continue
+		}
+		if _, ok := file.Layers[exp.Layer]; !ok {
+			return fmt.Errorf("experiments: %s, unknown layer %s", name, exp.Layer)
+		}
+		if !exp.Disabled {
+			layers[exp.Layer] = append(layers[exp.Layer], segment{experiment: name, traffic: traffic})
+		}
+	}
+
+	for layer, segments := range layers {
+		sort.Slice(segments, func(i, j int) bool { return segments[i].traffic.From < segments[j].traffic.From })
+		for i := 1; i < len(segments); i++ {
+			if segments[i].traffic.From < segments[i-1].traffic.To {
+				return fmt.Errorf(
+					"%w, layer %s, experiments %s and %s",
+					ErrLayerOverlap, layer, segments[i-1].experiment, segments[i].experiment,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+func (exp Experiment) traffic() Traffic {
+	if exp.Traffic == nil {
+		return Traffic{From: 0, To: 100}
+	}
+	return *exp.Traffic
+}
+
+func (exp Experiment) includes(layers map[string]Layer, unit string) bool {
+	salt := exp.Salt
+	if exp.Layer != "" {
+		salt = layers[exp.Layer].Salt
+	}
+	bucket := float64(hash(salt, unit)) * 100 / Buckets
+	traffic := exp.traffic()
+	return traffic.From <= bucket && bucket < traffic.To
+}
+
+// pick uses a hash independent of the traffic one, so resizing the traffic doesn't move units between variants.
+func (exp Experiment) pick(unit string) Variant {
+	total := 0.0
+	for _, variant := range exp.Variants {
+		total += variant.Weight
+	}
+	point := float64(hash(exp.Salt+":variant", unit)) * total / Buckets
+	for _, variant := range exp.Variants {
+		if point < variant.Weight {
+			return variant
+		}
+		point -= variant.Weight
+	}
+	return exp.Variants[len(exp.Variants)-1]
+}
+
+func hash(salt string, unit string) uint64 {
+	sum := sha256.Sum256([]byte(salt + ":" + unit))
+	return binary.BigEndian.Uint64(sum[:8]) % Buckets
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{assignments: make(map[[2]string]string)}
+}
+
+type MemoryStore struct {
+	lock        sync.RWMutex
+	assignments map[[2]string]string
+}
+
+func (store *MemoryStore) Load(ctx context.Context, experiment string, unit string) (string, bool, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if ctx.Err() != nil {
+		return "", false, ctx.Err()
+	}
+	variant, ok := store.assignments[[2]string{experiment, unit}]
+	return variant, ok, nil
+}
+
+func (store *MemoryStore) Save(ctx context.Context, experiment string, unit string, variant string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	store.assignments[[2]string{experiment, unit}] = variant
+	return nil
+}
@@ -0,0 +1,188 @@
+package experiments_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kittenbark/config"
+	"github.com/kittenbark/config/experiments"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeExperiments(t *testing.T, dir string, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "experiments.json"), []byte(data), 0644); err != nil {
+		t.Fatalf("error while writing config: %v", err)
+	}
+}
+
+func TestExperiments_Sticky(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeExperiments(t, dir, `{
+		"layers": {"checkout": {"salt": "checkout-v1"}},
+		"experiments": {
+			"button_color": {
+				"salt": "button-color-v1",
+				"layer": "checkout",
+				"traffic": {"from": 0, "to": 30},
+				"variants": [{"name": "control", "weight": 1}, {"name": "blue", "weight": 1, "value": {"color": "#00f"}}]
+			},
+			"button_size": {
+				"salt": "button-size-v1",
+				"layer": "checkout",
+				"traffic": {"from": 50, "to": 100},
+				"variants": [{"name": "control", "weight": 1}, {"name": "large", "weight": 1}]
+			}
+		}
+	}`)
+
+	exposures := map[string]int{}
+	cache := config.NewCache(dir).SyncTimeout(0)
+	assigner := experiments.NewAssigner(cache, "experiments").
+		Sink(experiments.SinkFunc(func(ctx context.Context, exposure experiments.Exposure) error {
+			exposures[exposure.Experiment]++
+			return nil
+		}))
+
+	variants := map[string]string{}
+	included := 0
+	for i := range 1000 {
+		unit := fmt.Sprintf("user_%d", i)
+		color, err := assigner.Assign("button_color", unit)
+		if err != nil {
+			t.Fatalf("error while assigning: %v", err)
+		}
+		size, err := assigner.Assign("button_size", unit)
+		if err != nil {
+			t.Fatalf("error while assigning: %v", err)
+		}
+		if color.Included && size.Included {
+			t.Fatalf("unit %s is in two experiments of the same layer", unit)
+		}
+		if again, _ := assigner.Assign("button_color", unit); again.Variant != color.Variant {
+			t.Fatalf("unit %s reassigned from %s to %s", unit, color.Variant, again.Variant)
+		}
+		if color.Included {
+			included++
+			variants[unit] = color.Variant
+		}
+	}
+	if included < 200 || included > 400 {
+		t.Fatalf("expected ~300 units in 30%% of traffic, actual: %d", included)
+	}
+	if exposures["button_color"] != 2*included {
+		t.Fatalf("expected %d exposures, actual: %d", 2*included, exposures["button_color"])
+	}
+
+	// Growing the traffic keeps everyone already included on their variant.
+	writeExperiments(t, dir, `{
+		"layers": {"checkout": {"salt": "checkout-v1"}},
+		"experiments": {
+			"button_color": {
+				"salt": "button-color-v1",
+				"layer": "checkout",
+				"traffic": {"from": 0, "to": 50},
+				"variants": [{"name": "control", "weight": 1}, {"name": "blue", "weight": 1, "value": {"color": "#00f"}}]
+			}
+		}
+	}`)
+	for unit, variant := range variants {
+		assignment, err := assigner.Assign("button_color", unit)
+		if err != nil {
+			t.Fatalf("error while assigning: %v", err)
+		}
+		if !assignment.Included || assignment.Variant != variant {
+			t.Fatalf("unit %s reshuffled: %+v, expected %s", unit, assignment, variant)
+		}
+	}
+
+	if _, err := assigner.Assign("button_size", "user_0"); !errors.Is(err, experiments.ErrUnknownExperiment) {
+		t.Fatalf("expected unknown experiment error, actual: %v", err)
+	}
+}
+
+func TestExperiments_Store(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeExperiments(t, dir, `{"experiments": {"price": {"salt": "price-v1", "variants": [
+		{"name": "control", "weight": 1}, {"name": "discount", "weight": 1}
+	]}}}`)
+
+	cache := config.NewCache(dir).SyncTimeout(0)
+	assigner := experiments.NewAssigner(cache, "experiments").Store(experiments.NewMemoryStore())
+	variants := map[string]string{}
+	for i := range 100 {
+		unit := fmt.Sprintf("user_%d", i)
+		assignment, err := assigner.Assign("price", unit)
+		if err != nil {
+			t.Fatalf("error while assigning: %v", err)
+		}
+		variants[unit] = assignment.Variant
+	}
+
+	writeExperiments(t, dir, `{"experiments": {"price": {"salt": "price-v1", "variants": [
+		{"name": "control", "weight": 1}, {"name": "discount", "weight": 9}
+	]}}}`)
+	for unit, variant := range variants {
+		assignment, err := assigner.Assign("price", unit)
+		if err != nil {
+			t.Fatalf("error while assigning: %v", err)
+		}
+		if assignment.Variant != variant {
+			t.Fatalf("unit %s reshuffled from %s to %s", unit, variant, assignment.Variant)
+		}
+	}
+
+	// A reload failing validation keeps the last valid config, assignments don't stop.
+	writeExperiments(t, dir, `{"experiments": {"price": {"salt": "price-v1", "variants": []}}}`)
+	for unit, variant := range variants {
+		assignment, err := assigner.Assign("price", unit)
+		if err != nil {
+			t.Fatalf("error while assigning after a bad reload: %v", err)
+		}
+		if assignment.Variant != variant {
+			t.Fatalf("unit %s reshuffled from %s to %s after a bad reload", unit, variant, assignment.Variant)
+		}
+	}
+	writeExperiments(t, dir, `{"experiments": {"price": `)
+	if _, err := assigner.Assign("price", "user_0"); err != nil {
+		t.Fatalf("error while assigning after an undecodable reload: %v", err)
+	}
+
+	// Without a valid config to fall back to, the validation error is returned.
+	writeExperiments(t, dir, `{"experiments": {"price": {"salt": "price-v1", "variants": []}}}`)
+	if _, err := experiments.NewAssigner(cache, "experiments").Assign("price", "user_0"); err == nil {
+		t.Fatalf("expected an error for an invalid config")
+	}
+}
+
+func TestExperiments_Validate(t *testing.T) {
+	t.Parallel()
+	for data, expected := range map[string]error{
+		`{"layers": {"l": {"salt": "l"}}, "experiments": {
+			"a": {"salt": "a", "layer": "l", "traffic": {"from": 0, "to": 60}, "variants": [{"name": "control", "weight": 1}]},
+			"b": {"salt": "b", "layer": "l", "traffic": {"from": 50, "to": 100}, "variants": [{"name": "control", "weight": 1}]}
+		}}`: experiments.ErrLayerOverlap,
+		`{"experiments": {
+			"a": {"variants": [{"name": "control", "weight": 1}]},
+			"b": {"variants": [{"name": "control", "weight": 1}]}
+		}}`: experiments.ErrInvalidSalt,
+		`{"experiments": {
+			"a": {"salt": "same", "variants": [{"name": "control", "weight": 1}]},
+			"b": {"salt": "same", "variants": [{"name": "control", "weight": 1}]}
+		}}`: experiments.ErrInvalidSalt,
+		`{"layers": {"l": {"salt": ""}}, "experiments": {}}`: experiments.ErrInvalidSalt,
+	} {
+		var file experiments.File
+		if err := json.Unmarshal([]byte(data), &file); err != nil {
+			t.Fatalf("error while decoding experiments: %v", err)
+		}
+		if err := file.Validate(); !errors.Is(err, expected) {
+			t.Fatalf("expected %v, actual: %v", expected, err)
+		}
+	}
+}
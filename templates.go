@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const TemplatesDirectory = "_templates"
+
+// ErrInvalidInstantiate marks the errors of Instantiate caused by its arguments rather than the templates or the directory.
+var ErrInvalidInstantiate = errors.New("config: invalid instantiate")
+
+// Instantiate renders every config of _templates/<name>/*.json with params (text/template syntax, e.g. "{{.TenantID}}")
+// and creates them in the namespace subdirectory, an empty namespace being the directory itself. Params are json
+// encoded before rendering: strings without the quotes, so they can't break out of the quoted placeholders, and any
+// other value as is. Either all configs are created or none: any render error, invalid json or already existing
+// config fails the whole template. Returns the names of the created configs.
+func (cache *Cache) Instantiate(ctx context.Context, name string, params map[string]any, namespace string) ([]string, error) {
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `*?[\`) {
+		return nil, fmt.Errorf("%w %q, template must be a plain path within the templates directory", ErrInvalidInstantiate, name)
+	}
+	if namespace != "" && !filepath.IsLocal(namespace) {
+		return nil, fmt.Errorf("%w %s, namespace %q must be a path within the directory", ErrInvalidInstantiate, name, namespace)
+	}
+	// Configs rendered into the templates directory would become templates themselves.
+	if top, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(namespace)), "/"); top == TemplatesDirectory {
+		return nil, fmt.Errorf("%w %s, namespace %q is within %s", ErrInvalidInstantiate, name, namespace, TemplatesDirectory)
+	}
+
+	templateDir := filepath.Join(cache.directory, TemplatesDirectory, name)
+	entries, err := os.ReadDir(templateDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+	}
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, filepath.Join(templateDir, entry.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("config: instantiate %s, template not found %w", name, fs.ErrNotExist)
+	}
+	escaped := make(map[string]string, len(params))
+	for key, value := range params {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s, param %s %w", ErrInvalidInstantiate, name, key, err)
+		}
+		if _, ok := value.(string); ok {
+			data = data[1 : len(data)-1]
+		}
+		escaped[key] = string(data)
+	}
+
+	type rendered struct {
+		name   string
+		target string
+		data   []byte
+	}
+	configs := make([]rendered, 0, len(paths))
+	for _, templatePath := range paths {
+		tmpl, err := template.New(filepath.Base(templatePath)).Option("missingkey=error").ParseFiles(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, escaped); err != nil {
+			return nil, fmt.Errorf("%w %s, %w", ErrInvalidInstantiate, name, err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return nil, fmt.Errorf("%w %s, %s renders into invalid json", ErrInvalidInstantiate, name, filepath.Base(templatePath))
+		}
+		base := filepath.Base(templatePath)
+		configs = append(configs, rendered{
+			name:   path.Join(filepath.ToSlash(namespace), strings.TrimSuffix(base, ".json")),
+			target: filepath.Join(cache.directory, namespace, base),
+			data:   buf.Bytes(),
+		})
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, cfg := range configs {
+		if _, err := os.Stat(cfg.target); err == nil {
+			return nil, fmt.Errorf("config: instantiate %s, %s %w", name, cfg.name, fs.ErrExist)
+		}
+	}
+	targetDir := filepath.Join(cache.directory, namespace)
+	if err := os.MkdirAll(targetDir, 0777); err != nil {
+		return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+	}
+
+	// Everything is written to temporary files first, linking them into place is the cheap part rolled back on failure.
+	// Unlike renaming, linking fails on an existing config, even one created by another process after the check above.
+	temps := make([]string, 0, len(configs))
+	defer func() {
+		for _, temp := range temps {
+			_ = os.Remove(temp)
+		}
+	}()
+	for _, cfg := range configs {
+		temp, err := os.CreateTemp(targetDir, ".instantiate.*")
+		if err != nil {
+			return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+		}
+		temps = append(temps, temp.Name())
+		_, err = temp.Write(cfg.data)
+		if err = errors.Join(err, temp.Close()); err != nil {
+			return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+		}
+	}
+
+	created := make([]string, 0, len(configs))
+	for i, cfg := range configs {
+		if err := os.Link(temps[i], cfg.target); err != nil {
+			for _, done := range configs[:i] {
+				_ = os.Remove(done.target)
+			}
+			return nil, fmt.Errorf("config: instantiate %s, %w", name, err)
+		}
+		created = append(created, cfg.name)
+	}
+	return created, nil
+}
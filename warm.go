@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type warmStartFile struct {
+	Directory string                     `json:"directory"`
+	Configs   map[string]warmStartConfig `json:"configs"`
+}
+
+type warmStartConfig struct {
+	LastUpdate time.Time `json:"last_update"`
+	Raw        []byte    `json:"raw"`
+}
+
+// WarmStart restores the cache from the warm-start file at path (a missing file is not an error),
+// revalidates the restored configs against the directory in the background, saves the snapshot
+// every interval (never if interval <= 0) and once more when ctx is done. The returned channel is
+// closed after that last save, wait for it before exiting.
+func (cache *Cache) WarmStart(ctx context.Context, path string, interval time.Duration) (<-chan struct{}, error) {
+	if err := cache.LoadWarmStart(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cache.Revalidate(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("config: warm start, revalidate", "err", err)
+		}
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				if err := cache.SaveWarmStart(path); err != nil {
+					slog.Error("config: warm start, save on shutdown", "err", err, "path", path)
+				}
+				return
+			case <-tick:
+				if err := cache.SaveWarmStart(path); err != nil {
+					slog.Error("config: warm start, save", "err", err, "path", path)
+				}
+			}
+		}
+	}()
+	return done, nil
+}
+
+func (cache *Cache) SaveWarmStart(path string) error {
+	directory, err := filepath.Abs(cache.directory)
+	if err != nil {
+		return fmt.Errorf("config: warm start, save %w", err)
+	}
+	cache.lock.RLock()
+	snapshot := warmStartFile{
+		Directory: directory,
+		Configs:   make(map[string]warmStartConfig, len(cache.configs)),
+	}
+	for name, cfg := range cache.configs {
+		snapshot.Configs[name] = warmStartConfig{LastUpdate: cfg.LastUpdate, Raw: cfg.Raw}
+	}
+	cache.lock.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("config: warm start, encode %w", err)
+	}
+
+	// Write to a temporary file first, so a crash mid-save never leaves a truncated snapshot behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("config: warm start, save %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("config: warm start, save %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("config: warm start, save %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("config: warm start, save %w", err)
+	}
+	return nil
+}
+
+func (cache *Cache) LoadWarmStart(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: warm start, load %w", err)
+	}
+	var snapshot warmStartFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("config: warm start, decode %w", err)
+	}
+
+	// Relative directories are resolved against the working directory, so "." of another process is another directory.
+	directory, err := filepath.Abs(cache.directory)
+	if err != nil {
+		return fmt.Errorf("config: warm start, load %w", err)
+	}
+	snapshotDirectory, err := filepath.Abs(snapshot.Directory)
+	if err != nil {
+		return fmt.Errorf("config: warm start, load %w", err)
+	}
+	if snapshotDirectory != directory {
+		return fmt.Errorf("config: warm start, snapshot of %q does not match directory %q", snapshotDirectory, directory)
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for name, saved := range snapshot.Configs {
+		if current, ok := cache.configs[name]; ok && !saved.LastUpdate.After(current.LastUpdate) {
+			continue
+		}
+		// LastUpdate is kept as saved, so configs changed on disk after the snapshot are picked up by
+		// Revalidate or by the first Get past the sync timeout.
+		cache.configs[name] = &configValue{
+			LastUpdate: saved.LastUpdate,
+			Raw:        saved.Raw,
+		}
+	}
+	return nil
+}
+
+// Revalidate checks every cached config against the directory regardless of the sync timeout,
+// reloading the changed ones and dropping the ones that no longer exist.
+func (cache *Cache) Revalidate(ctx context.Context) error {
+	cache.lock.RLock()
+	names := make([]string, 0, len(cache.configs))
+	for name := range cache.configs {
+		names = append(names, name)
+	}
+	cache.lock.RUnlock()
+
+	var errs []error
+	for _, name := range names {
+		if err := cache.revalidate(ctx, name); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("config: revalidate %s, %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cache *Cache) revalidate(ctx context.Context, name string) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	config, ok := cache.configs[name]
+	if !ok {
+		return nil
+	}
+	path := filepath.Join(cache.directory, fmt.Sprintf("%s.json", strings.TrimSpace(name)))
+	stat, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		delete(cache.configs, name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !stat.ModTime().After(config.LastUpdate) {
+		return nil
+	}
+
+	loaded := time.Now()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cache.configs[name] = &configValue{
+		LastUpdate: loaded,
+		Raw:        data,
+	}
+	return nil
+}